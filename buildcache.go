@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// cachedResult is the outcome of a successful build pipeline run, recorded
+// so an identical tracked-file set can skip re-running it.
+type cachedResult struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output"`
+}
+
+// buildCache remembers the result of the last successful build for a given
+// cache key; see buildCacheKey. A nil buildCache (the "off" default)
+// disables the feature, so every call site must check for nil before
+// calling Get/Put.
+type buildCache interface {
+	Get(key string) (cachedResult, bool)
+	Put(key string, result cachedResult)
+}
+
+// memoryBuildCache is the -cache=memory backend: it remembers results only
+// for the lifetime of the current process.
+type memoryBuildCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+func newMemoryBuildCache() *memoryBuildCache {
+	return &memoryBuildCache{entries: make(map[string]cachedResult)}
+}
+
+func (c *memoryBuildCache) Get(key string) (cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.entries[key]
+	return result, ok
+}
+
+func (c *memoryBuildCache) Put(key string, result cachedResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = result
+}
+
+// diskBuildCache is the -cache=disk backend: each entry is a small JSON
+// file under dir, so results survive across CompileDaemon invocations
+// (e.g. a "once" run in CI followed by another).
+type diskBuildCache struct {
+	dir string
+}
+
+func newDiskBuildCache(dir string) (*diskBuildCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("build cache: %w", err)
+	}
+	return &diskBuildCache{dir: dir}, nil
+}
+
+func (c *diskBuildCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *diskBuildCache) Get(key string) (cachedResult, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return cachedResult{}, false
+	}
+
+	var result cachedResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return cachedResult{}, false
+	}
+	return result, true
+}
+
+func (c *diskBuildCache) Put(key string, result cachedResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(c.path(key), data, 0644); err != nil {
+		log.Println(failColor("build cache: could not write %s: %s", c.path(key), err))
+	}
+}
+
+// newBuildCache constructs the cache backend selected by -cache. Mode "off"
+// (the default) returns a nil buildCache, i.e. the feature is disabled.
+func newBuildCache(mode, dir string) (buildCache, error) {
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "memory":
+		return newMemoryBuildCache(), nil
+	case "disk":
+		if dir == "" {
+			var err error
+			dir, err = defaultCacheDir()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newDiskBuildCache(dir)
+	default:
+		return nil, fmt.Errorf("-cache: unrecognized mode %q, want off, memory or disk", mode)
+	}
+}
+
+// defaultCacheDir is $XDG_CACHE_HOME/compiledaemon/<project-hash>, falling
+// back to ~/.cache when XDG_CACHE_HOME is unset.
+func defaultCacheDir() (string, error) {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("build cache: %w", err)
+		}
+		base = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(base, "compiledaemon", projectHash()), nil
+}
+
+// projectHash identifies the current project so -cache=disk's default
+// directory doesn't mix cache entries from unrelated projects on the same
+// machine.
+func projectHash() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = "."
+	}
+	sum := sha256.Sum256([]byte(wd))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// buildCacheKey identifies a build: it changes whenever anything about the
+// pipeline's steps changes (command, dir, env, pattern or dependencies -
+// so e.g. flipping a -build-step-env codegen flag always invalidates the
+// cache) or whenever the content of any tracked file changes.
+func buildCacheKey(steps []*buildStep, files []string) string {
+	h := sha256.New()
+	for _, s := range steps {
+		pattern := ""
+		if s.pattern != nil {
+			pattern = s.pattern.String()
+		}
+		writeField(h, "name", s.name)
+		writeField(h, "command", s.command)
+		writeField(h, "dir", s.dir)
+		for _, e := range s.env {
+			writeField(h, "env", e)
+		}
+		writeField(h, "pattern", pattern)
+		for _, a := range s.after {
+			writeField(h, "after", a)
+		}
+	}
+
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	for _, f := range sorted {
+		writeField(h, "file", f)
+		writeField(h, "hash", hashFile(f))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField hashes a length-prefixed key/value pair so that no combination
+// of field values can collide with a differently-split one (plain
+// delimiter-joining, e.g. strings.Join(vals, ","), lets "a,b"+"c" hash the
+// same as "a"+"b,c").
+func writeField(h io.Writer, key, value string) {
+	fmt.Fprintf(h, "%s:%d:%s\n", key, len(value), value)
+}
+
+// trackedFiles walks flagDirectories and returns every file that matches
+// the same include/exclude/pattern rules the fsnotify loop in runDaemon
+// uses, i.e. the exact file set a build depends on.
+func trackedFiles() []string {
+	pattern := regexp.MustCompile(flagPattern)
+
+	var files []string
+	for _, dir := range flagDirectories {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != dir && flagRecursive && flagExcludedDirs.Matches(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			base := filepath.Base(path)
+			if flagExcludedFiles.Matches(base) {
+				return nil
+			}
+			if flagIncludedFiles.Matches(base) || matchesPattern(pattern, path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	return files
+}
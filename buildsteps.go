@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// buildStep describes a single leg of the build pipeline: a command to run,
+// optionally scoped to a working directory and an extra set of environment
+// variables, gated on a pattern of changed files and on other steps that
+// have to complete successfully first.
+type buildStep struct {
+	name    string
+	command string
+	dir     string
+	env     []string
+	pattern *regexp.Regexp
+	after   []string
+}
+
+// keyedList collects repeated "name:value" flag occurrences into an
+// insertion-ordered map, mirroring the way globList accumulates repeated
+// glob flags.
+type keyedList struct {
+	order  []string
+	values map[string]string
+}
+
+func (k *keyedList) String() string {
+	return fmt.Sprint(k.values)
+}
+
+func (k *keyedList) Type() string {
+	return "keyedList"
+}
+
+func (k *keyedList) Set(value string) error {
+	name, rest, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("expected \"name:value\", got %q", value)
+	}
+
+	if k.values == nil {
+		k.values = make(map[string]string)
+	}
+
+	if _, exists := k.values[name]; !exists {
+		k.order = append(k.order, name)
+	}
+	k.values[name] = rest
+
+	return nil
+}
+
+// envList collects repeated "name:KEY=VALUE" flag occurrences into an
+// insertion-ordered map of slices. Unlike -build-step-dir/-pattern/-after,
+// which only make sense once per step, -build-step-env is documented as
+// repeatable per step, so each Set call must append rather than overwrite.
+type envList struct {
+	order  []string
+	values map[string][]string
+}
+
+func (e *envList) String() string {
+	return fmt.Sprint(e.values)
+}
+
+func (e *envList) Type() string {
+	return "envList"
+}
+
+func (e *envList) Set(value string) error {
+	name, rest, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("expected \"name:value\", got %q", value)
+	}
+
+	if e.values == nil {
+		e.values = make(map[string][]string)
+	}
+
+	if _, exists := e.values[name]; !exists {
+		e.order = append(e.order, name)
+	}
+	e.values[name] = append(e.values[name], rest)
+
+	return nil
+}
+
+// buildStepFlag accumulates "name:command" pairs supplied via repeated
+// -build-step flags, preserving the order they were given in.
+type buildStepFlag struct {
+	steps  []*buildStep
+	byName map[string]*buildStep
+}
+
+func (b *buildStepFlag) String() string {
+	names := make([]string, 0, len(b.steps))
+	for _, s := range b.steps {
+		names = append(names, s.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (b *buildStepFlag) Type() string {
+	return "buildStep"
+}
+
+func (b *buildStepFlag) Set(value string) error {
+	name, command, ok := strings.Cut(value, ":")
+	if !ok || name == "" || command == "" {
+		return fmt.Errorf("-build-step expects \"name:command\", got %q", value)
+	}
+
+	if b.byName == nil {
+		b.byName = make(map[string]*buildStep)
+	}
+
+	if _, exists := b.byName[name]; exists {
+		return fmt.Errorf("duplicate -build-step name %q", name)
+	}
+
+	step := &buildStep{name: name, command: command}
+	b.byName[name] = step
+	b.steps = append(b.steps, step)
+
+	return nil
+}
+
+// applyStepDirs, applyStepEnvs, applyStepPatterns and applyStepDeps fold the
+// companion -build-step-* flags into the steps collected by a
+// buildStepFlag. They are applied after flags have been parsed so that
+// steps can be referenced regardless of the order the flags were given on
+// the command line.
+func applyStepDirs(steps *buildStepFlag, dirs *keyedList) error {
+	for _, name := range dirs.order {
+		step, ok := steps.byName[name]
+		if !ok {
+			return fmt.Errorf("-build-step-dir refers to unknown build step %q", name)
+		}
+		step.dir = dirs.values[name]
+	}
+	return nil
+}
+
+func applyStepEnvs(steps *buildStepFlag, envs *envList) error {
+	for _, name := range envs.order {
+		step, ok := steps.byName[name]
+		if !ok {
+			return fmt.Errorf("-build-step-env refers to unknown build step %q", name)
+		}
+		step.env = append(step.env, envs.values[name]...)
+	}
+	return nil
+}
+
+func applyStepPatterns(steps *buildStepFlag, patterns *keyedList) error {
+	for _, name := range patterns.order {
+		step, ok := steps.byName[name]
+		if !ok {
+			return fmt.Errorf("-build-step-pattern refers to unknown build step %q", name)
+		}
+		pattern, err := regexp.Compile(patterns.values[name])
+		if err != nil {
+			return fmt.Errorf("-build-step-pattern for %q: %s", name, err)
+		}
+		step.pattern = pattern
+	}
+	return nil
+}
+
+func applyStepDeps(steps *buildStepFlag, deps *keyedList) error {
+	for _, name := range deps.order {
+		step, ok := steps.byName[name]
+		if !ok {
+			return fmt.Errorf("-build-step-after refers to unknown build step %q", name)
+		}
+		for _, dep := range strings.Split(deps.values[name], ",") {
+			dep = strings.TrimSpace(dep)
+			if dep == "" {
+				continue
+			}
+			if _, ok := steps.byName[dep]; !ok {
+				return fmt.Errorf("build step %q depends on unknown step %q", name, dep)
+			}
+			step.after = append(step.after, dep)
+		}
+	}
+	return nil
+}
+
+// orderedSteps returns the given steps sorted so that every step appears
+// after everything it depends on (a topological sort of the "after" DAG).
+// It returns an error if the dependencies contain a cycle.
+func orderedSteps(steps []*buildStep) ([]*buildStep, error) {
+	byName := make(map[string]*buildStep, len(steps))
+	for _, s := range steps {
+		byName[s.name] = s
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(steps))
+	ordered := make([]*buildStep, 0, len(steps))
+
+	var visit func(s *buildStep) error
+	visit = func(s *buildStep) error {
+		switch state[s.name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("build step %q is part of a dependency cycle", s.name)
+		}
+
+		state[s.name] = visiting
+		for _, depName := range s.after {
+			if err := visit(byName[depName]); err != nil {
+				return err
+			}
+		}
+		state[s.name] = done
+		ordered = append(ordered, s)
+
+		return nil
+	}
+
+	for _, s := range steps {
+		if err := visit(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// descendants returns the set of step names (including the starting set
+// itself) reachable by following "after" edges forward, i.e. every step
+// that depends, directly or transitively, on one of the given steps.
+func descendants(all []*buildStep, triggered map[string]bool) map[string]bool {
+	affected := make(map[string]bool, len(triggered))
+	for name := range triggered {
+		affected[name] = true
+	}
+
+	// Repeat until a fixed point: a step is affected if any of its
+	// dependencies are affected.
+	for changed := true; changed; {
+		changed = false
+		for _, s := range all {
+			if affected[s.name] {
+				continue
+			}
+			for _, dep := range s.after {
+				if affected[dep] {
+					affected[s.name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	return affected
+}
+
+// stepsTriggeredBy returns the steps (in dependency order) that must run in
+// response to a change at path: every step whose pattern matches path, plus
+// everything downstream of it. Steps without a pattern are treated as
+// always-triggered, matching the behaviour of the legacy single -build flag.
+func stepsTriggeredBy(ordered []*buildStep, path string) []*buildStep {
+	triggered := make(map[string]bool)
+	for _, s := range ordered {
+		if s.pattern == nil || s.pattern.MatchString(path) {
+			triggered[s.name] = true
+		}
+	}
+
+	if len(triggered) == 0 {
+		return nil
+	}
+
+	affected := descendants(ordered, triggered)
+
+	result := make([]*buildStep, 0, len(affected))
+	for _, s := range ordered {
+		if affected[s.name] {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// runStep executes a single build step and returns its combined
+// stdout/stderr output alongside the error from running it, if any.
+func runStep(s *buildStep) ([]byte, error) {
+	args := strings.Split(s.command, " ")
+	cmd := exec.Command(args[0], args[1:]...)
+
+	if s.dir != "" {
+		cmd.Dir = s.dir
+	}
+
+	if len(s.env) > 0 {
+		cmd.Env = append(cmd.Environ(), s.env...)
+	}
+
+	return cmd.CombinedOutput()
+}
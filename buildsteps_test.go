@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+// TestEnvListAccumulatesRepeatedFlags guards against a regression where
+// repeated -build-step-env flags for the same step silently dropped all but
+// the last value because they shared keyedList, which only keeps one value
+// per name.
+func TestEnvListAccumulatesRepeatedFlags(t *testing.T) {
+	var envs envList
+	if err := envs.Set("compile:FOO=1"); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := envs.Set("compile:BAR=2"); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	got := envs.values["compile"]
+	want := []string{"FOO=1", "BAR=2"}
+	if len(got) != len(want) {
+		t.Fatalf("values[\"compile\"] = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("values[\"compile\"] = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestApplyStepEnvsAccumulates(t *testing.T) {
+	steps := &buildStepFlag{byName: map[string]*buildStep{
+		"compile": {name: "compile"},
+	}}
+
+	var envs envList
+	envs.Set("compile:FOO=1")
+	envs.Set("compile:BAR=2")
+
+	if err := applyStepEnvs(steps, &envs); err != nil {
+		t.Fatalf("applyStepEnvs: %s", err)
+	}
+
+	step := steps.byName["compile"]
+	want := []string{"FOO=1", "BAR=2"}
+	if len(step.env) != len(want) {
+		t.Fatalf("step.env = %v, want %v", step.env, want)
+	}
+	for i := range want {
+		if step.env[i] != want[i] {
+			t.Fatalf("step.env = %v, want %v", step.env, want)
+		}
+	}
+}
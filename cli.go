@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "CompileDaemon",
+	Short: "CompileDaemon watches your files and rebuilds/restarts your program when they change",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return applyConfigFile(cmd)
+	},
+	// Run the default behavior (equivalent to the "run" subcommand) when
+	// invoked with no subcommand, same as every CompileDaemon release
+	// before this one.
+	Run: func(cmd *cobra.Command, args []string) {
+		runDaemon()
+	},
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Watch for changes and rebuild/restart continuously (default)",
+	Run: func(cmd *cobra.Command, args []string) {
+		runDaemon()
+	},
+}
+
+var onceCmd = &cobra.Command{
+	Use:   "once",
+	Short: "Run the configured build pipeline exactly once and exit, for use in CI",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !runOnce() {
+			os.Exit(1)
+		}
+	},
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate the configuration and print the resolved watch set",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := printResolvedConfig(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	registerFlags(rootCmd.PersistentFlags())
+	rootCmd.AddCommand(runCmd, onceCmd, checkCmd)
+}
+
+// registerFlags defines every CompileDaemon flag on flags. Doing this once
+// against the root command's persistent flags, rather than per
+// subcommand, is what makes every flag available under "run", "once" and
+// "check" alike, and also what makes every flag a candidate config file
+// key and COMPILEDAEMON_ environment variable in applyConfigFile.
+func registerFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&flagPattern, "pattern", flagPattern, "Pattern of watched files")
+	flags.StringVar(&flagCommand, "command", flagCommand, "Command to run and restart after build")
+	flags.BoolVar(&flagCommandStop, "command-stop", flagCommandStop, "Stop command before building")
+	flags.BoolVar(&flagRecursive, "recursive", flagRecursive, "Watch all dirs. recursively")
+	flags.StringVar(&flagBuild, "build", flagBuild, "Command to rebuild after changes")
+	flags.StringVar(&flagBuildDir, "build-dir", flagBuildDir, "Directory to run build command in.  Defaults to directory")
+	flags.StringVar(&flagRunDir, "run-dir", flagRunDir, "Directory to run command in.  Defaults to directory")
+	flags.BoolVar(&flagColor, "color", flagColor, "Colorize output for CompileDaemon status messages")
+	flags.BoolVar(&flagLogPrefix, "log-prefix", flagLogPrefix, "Print log timestamps and subprocess stderr/stdout output")
+	flags.BoolVar(&flagGracefulKill, "graceful-kill", flagGracefulKill, "Gracefully attempt to kill the child process by sending a SIGTERM first")
+	flags.UintVar(&flagGracefulTimeout, "graceful-timeout", flagGracefulTimeout, "Duration (in seconds) to wait for graceful kill to complete")
+	flags.BoolVar(&flagVerbose, "verbose", flagVerbose, "Be verbose about which directories are watched.")
+	flags.StringVar(&flagLiveReload, "livereload", flagLiveReload, "Address (e.g. :35729) to serve a LiveReload websocket on after successful builds")
+	flags.DurationVar(&flagDebounce, "debounce", flagDebounce, "Ignore repeat events for the same file within this duration, e.g. 300ms")
+	flags.BoolVar(&flagHashCheck, "hash-check", flagHashCheck, "Drop events whose file content hash is unchanged since the last observation")
+	flags.IntVar(&flagSuppressCache, "suppress-cache-size", flagSuppressCache, "Max number of paths the event suppressor remembers")
+	flags.StringVar(&flagEventStream, "event-stream", flagEventStream, "Emit newline-delimited JSON events to stdout, unix:/path/to.sock or tcp::9000")
+	flags.StringVar(&flagCache, "cache", flagCache, "Skip rebuilding when the tracked file set is unchanged: off, memory or disk")
+	flags.StringVar(&flagCacheDir, "cache-dir", flagCacheDir, "Directory for -cache=disk entries, defaults to $XDG_CACHE_HOME/compiledaemon/<project-hash>")
+
+	flags.Var(&flagDirectories, "directory", "Directory to watch for changes, can be set more than once")
+	flags.Var(&flagExcludedDirs, "exclude-dir", "Don't watch directories matching this name, can be set more than once")
+	flags.Var(&flagExcludedFiles, "exclude", "Don't watch files matching this name, can be set more than once")
+	flags.Var(&flagIncludedFiles, "include", "Watch files matching this name, can be set more than once")
+	flags.Var(&flagBuildSteps, "build-step", "Build pipeline step \"name:command\", can be set more than once; overrides -build")
+	flags.Var(&flagStepDirs, "build-step-dir", "Working directory for a build step \"name:dir\"")
+	flags.Var(&flagStepEnvs, "build-step-env", "Extra environment variable for a build step \"name:KEY=VALUE\", can be set more than once")
+	flags.Var(&flagStepPatterns, "build-step-pattern", "Regexp of changed files that trigger a build step \"name:pattern\"")
+	flags.Var(&flagStepDeps, "build-step-after", "Comma separated build steps that must run before a step \"name:dep1,dep2\"")
+}
+
+// applyConfigFile loads .compiledaemon.yaml/.toml (from the working
+// directory or $XDG_CONFIG_HOME/compiledaemon) and COMPILEDAEMON_-prefixed
+// environment variables, then applies them to every flag that wasn't set
+// explicitly on the command line. Flags set on the command line always
+// win, matching the usual config-file/env/flag precedence.
+func applyConfigFile(cmd *cobra.Command) error {
+	v := viper.New()
+	v.SetConfigName(".compiledaemon")
+	v.AddConfigPath(".")
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		v.AddConfigPath(filepath.Join(xdg, "compiledaemon"))
+	}
+
+	v.SetEnvPrefix("COMPILEDAEMON")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	if err := v.ReadInConfig(); err != nil {
+		if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+	}
+
+	var applyErr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if applyErr != nil || f.Changed || !v.IsSet(f.Name) {
+			return
+		}
+
+		switch f.Value.Type() {
+		case "globList", "keyedList", "envList", "buildStep":
+			for _, item := range v.GetStringSlice(f.Name) {
+				if err := f.Value.Set(item); err != nil {
+					applyErr = fmt.Errorf("applying config value for -%s: %w", f.Name, err)
+					return
+				}
+			}
+		default:
+			if err := f.Value.Set(v.GetString(f.Name)); err != nil {
+				applyErr = fmt.Errorf("applying config value for -%s: %w", f.Name, err)
+			}
+		}
+	})
+
+	return applyErr
+}
+
+// knownSubcommands lists the cobra subcommands normalizeLegacyArgs must
+// not mistake for the start of a flag list.
+var knownSubcommands = map[string]bool{
+	"run": true, "once": true, "check": true,
+	"completion": true, "help": true,
+}
+
+// normalizeLegacyArgs is the compatibility shim that keeps pre-cobra
+// invocations working: a bare flag list with no subcommand is treated as
+// "run ...", and single-dash long options (e.g. -build=x, as accepted by
+// the old stdlib `flag` package) are rewritten to the double-dash form
+// pflag requires for anything but a single-letter shorthand.
+func normalizeLegacyArgs(args []string) []string {
+	result := append([]string(nil), args...)
+
+	if len(result) == 0 || strings.HasPrefix(result[0], "-") {
+		result = append([]string{"run"}, result...)
+	} else if !knownSubcommands[result[0]] {
+		return result
+	}
+
+	for i, a := range result {
+		if i == 0 || !strings.HasPrefix(a, "-") || strings.HasPrefix(a, "--") {
+			continue
+		}
+		name := strings.TrimPrefix(a, "-")
+		if len(name) > 1 {
+			result[i] = "--" + name
+		}
+	}
+
+	return result
+}
+
+func main() {
+	rootCmd.SetArgs(normalizeLegacyArgs(os.Args[1:]))
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
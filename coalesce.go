@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default cap on the number of paths an eventSuppressor remembers. Chosen
+// to comfortably cover a large watched tree without letting a long-running
+// daemon grow its suppression map without bound.
+const defaultSuppressCacheSize = 8192
+
+// observation is what an eventSuppressor remembers about the last event it
+// let through for a given path.
+type observation struct {
+	seenAt time.Time
+	digest string
+}
+
+// eventSuppressor coalesces the raw stream of filesystem events fsnotify
+// and the polling watcher deliver into the ones that actually matter: it
+// drops the well-known "double write" duplicate that shows up within a
+// short window of the first event, and (optionally) drops events where the
+// file's content hash hasn't actually changed, which catches editors that
+// save-via-rename or merely touch a file's mtime.
+//
+// It is safe for concurrent use and keeps at most capacity entries,
+// evicting the least recently used path once that cap is reached.
+type eventSuppressor struct {
+	debounce  time.Duration
+	hashCheck bool
+	capacity  int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+type suppressorEntry struct {
+	path string
+	obs  observation
+}
+
+func newEventSuppressor(debounce time.Duration, hashCheck bool, capacity int) *eventSuppressor {
+	if capacity <= 0 {
+		capacity = defaultSuppressCacheSize
+	}
+
+	return &eventSuppressor{
+		debounce:  debounce,
+		hashCheck: hashCheck,
+		capacity:  capacity,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// ShouldSuppress reports whether the event for path should be dropped: it
+// is a duplicate seen within the debounce window, or its content hash
+// matches what was last observed. As a side effect it records path as seen
+// now, with the newly computed digest, unless the event is suppressed for
+// being a content duplicate.
+func (s *eventSuppressor) ShouldSuppress(path string) bool {
+	if s == nil {
+		return false
+	}
+
+	now := time.Now()
+	digest := ""
+	if s.hashCheck {
+		digest = hashFile(path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[path]; ok {
+		prev := elem.Value.(*suppressorEntry).obs
+
+		if s.debounce > 0 && now.Sub(prev.seenAt) < s.debounce {
+			s.touch(elem)
+			return true
+		}
+
+		if s.hashCheck && digest != "" && digest == prev.digest {
+			elem.Value.(*suppressorEntry).obs.seenAt = now
+			s.touch(elem)
+			return true
+		}
+	}
+
+	s.record(path, observation{seenAt: now, digest: digest})
+
+	return false
+}
+
+func (s *eventSuppressor) touch(elem *list.Element) {
+	s.order.MoveToFront(elem)
+}
+
+func (s *eventSuppressor) record(path string, obs observation) {
+	if elem, ok := s.entries[path]; ok {
+		elem.Value.(*suppressorEntry).obs = obs
+		s.touch(elem)
+		return
+	}
+
+	elem := s.order.PushFront(&suppressorEntry{path: path, obs: obs})
+	s.entries[path] = elem
+
+	for len(s.entries) > s.capacity {
+		oldest := s.order.Back()
+		if oldest == nil {
+			break
+		}
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*suppressorEntry).path)
+	}
+}
+
+// hashFileSizeLimit caps how much of a file we'll read to hash it; beyond
+// this we fall back to a cheap mtime+size fingerprint so a single large
+// asset can't make every save block on a full read.
+const hashFileSizeLimit = 32 << 20 // 32MiB
+
+// hashFile returns a short fingerprint for the current contents of path:
+// a sha256 digest of the file for anything under hashFileSizeLimit, or a
+// combination of mtime and size for larger files or files that can no
+// longer be statted (e.g. because they were just deleted, in which case an
+// empty string is returned so the event is never suppressed).
+func hashFile(path string) string {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+
+	if info.Size() > hashFileSizeLimit {
+		return fmt.Sprintf("mtime:%d:size:%d", info.ModTime().UnixNano(), info.Size())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
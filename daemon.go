@@ -4,6 +4,22 @@ CompileDaemon is a very simple compile daemon for Go.
 CompileDaemon watches your .go files in a directory and invokes `go build`
 if a file changes.
 
+Subcommands
+
+CompileDaemon is built around cobra subcommands:
+
+	run         – watch and rebuild/restart forever (the default if none is given)
+	once        – run the build pipeline exactly once and exit, for use in CI
+	check       – validate the configuration and print the resolved watch set
+	completion  – generate shell completion scripts
+
+Every flag below can also be set via a COMPILEDAEMON_ prefixed environment
+variable (e.g. COMPILEDAEMON_BUILD) or via a .compiledaemon.yaml/.toml file
+in the working directory or $XDG_CONFIG_HOME/compiledaemon, with command
+line flags taking precedence. Invoking CompileDaemon with a flat flag list
+and no subcommand, as in every release before this one, still works exactly
+as before.
+
 Examples
 
 In its simplest form, the defaults will do. With the current working directory set
@@ -53,17 +69,43 @@ There are command line options.
 	                    allow it to exit gracefully if possible.
 	-graceful-timeout - Duration (in seconds) to wait for graceful kill to complete
 	-verbose          - Print information about watched directories.
+	-livereload=ADDR  - Serve a LiveReload websocket on ADDR (e.g. :35729) and
+	                    notify connected browsers after every successful build
+	-debounce=Nms     - Ignore repeat events for the same file within this
+	                    duration, catching editors that save-via-rename twice
+	-hash-check       - Drop events whose file content hash is unchanged
+	                    since the last observation
+	-event-stream=X   - Emit newline-delimited JSON events (file_changed,
+	                    build_started, build_finished, process_started,
+	                    process_exited) to X: stdout, unix:/path/to.sock or
+	                    tcp::9000
+	-cache=X          - Skip rebuilding when every tracked file's content hash
+	                    matches the last successful build: off (default),
+	                    memory or disk
+	-cache-dir=XXX    - Directory for -cache=disk entries, defaults to
+	                    $XDG_CACHE_HOME/compiledaemon/<project-hash>
 
 	ACTIONS
 	-build=CCC        – Execute CCC to rebuild when a file changes
 	-command=CCC      – Run command CCC after a successful build, stops previous command first
 
+	BUILD PIPELINE
+	-build-step=NAME:CCC          – Add a pipeline step named NAME that runs CCC;
+	                                 can be repeated, overrides -build
+	-build-step-dir=NAME:DIR      – Working directory for step NAME
+	-build-step-env=NAME:K=V      – Extra environment variable for step NAME, repeatable
+	-build-step-pattern=NAME:RE   – Only run step NAME for changed files matching RE
+	-build-step-after=NAME:A,B    – Run step NAME after steps A and B have succeeded
+
+	A changed file triggers every step whose pattern matches it plus everything
+	that depends on those steps, so e.g. a *.proto change can re-run codegen
+	and everything downstream of it while leaving unrelated steps alone.
+
 */
 package main
 
 import (
 	"bufio"
-	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -83,6 +125,18 @@ import (
 // Milliseconds to wait for the next job to begin after a file change
 const WorkDelay = 900
 
+// liveReload is non-nil once -livereload has started the embedded
+// LiveReload server, and is notified by builder() after every successful
+// build.
+var liveReload *liveReloadServer
+
+// events is non-nil once -event-stream has started publishing the
+// structured JSON event stream; see eventstream.go.
+var events *eventHub
+
+// cache is non-nil once -cache has selected a backend; see buildcache.go.
+var cache buildCache
+
 // Default pattern to match files which trigger a build
 const FilePattern = `(.+\.go|.+\.c)$`
 
@@ -95,6 +149,9 @@ func (g *globList) Set(value string) error {
 	*g = append(*g, filepath.Clean(value))
 	return nil
 }
+func (g *globList) Type() string {
+	return "globList"
+}
 func (g *globList) Matches(value string) bool {
 	for _, v := range *g {
 		if match, err := filepath.Match(v, value); err != nil {
@@ -106,29 +163,50 @@ func (g *globList) Matches(value string) bool {
 	return false
 }
 
+// Every flag below is registered on the command line by registerFlags in
+// cli.go (which also makes it available as a config file key and a
+// COMPILEDAEMON_-prefixed environment variable); the defaults here are
+// only used if nothing sets them first.
 var (
-	flagPattern         = flag.String("pattern", FilePattern, "Pattern of watched files")
-	flagCommand         = flag.String("command", "", "Command to run and restart after build")
-	flagCommandStop     = flag.Bool("command-stop", false, "Stop command before building")
-	flagRecursive       = flag.Bool("recursive", true, "Watch all dirs. recursively")
-	flagBuild           = flag.String("build", "go build", "Command to rebuild after changes")
-	flagBuildDir        = flag.String("build-dir", "", "Directory to run build command in.  Defaults to directory")
-	flagRunDir          = flag.String("run-dir", "", "Directory to run command in.  Defaults to directory")
-	flagColor           = flag.Bool("color", false, "Colorize output for CompileDaemon status messages")
-	flagLogPrefix       = flag.Bool("log-prefix", true, "Print log timestamps and subprocess stderr/stdout output")
-	flagGracefulKill    = flag.Bool("graceful-kill", false, "Gracefully attempt to kill the child process by sending a SIGTERM first")
-	flagGracefulTimeout = flag.Uint("graceful-timeout", 3, "Duration (in seconds) to wait for graceful kill to complete")
-	flagVerbose         = flag.Bool("verbose", false, "Be verbose about which directories are watched.")
-
-	// initialized in main() due to custom type.
+	flagPattern         = FilePattern
+	flagCommand         = ""
+	flagCommandStop     = false
+	flagRecursive       = true
+	flagBuild           = "go build"
+	flagBuildDir        = ""
+
+	flagRunDir          = ""
+	flagColor           = false
+	flagLogPrefix       = true
+	flagGracefulKill    = false
+	flagGracefulTimeout = uint(3)
+	flagVerbose         = false
+	flagLiveReload      = ""
+	flagDebounce        = time.Duration(0)
+	flagHashCheck       = false
+	flagSuppressCache   = defaultSuppressCacheSize
+	flagEventStream     = ""
+	flagCache           = "off"
+	flagCacheDir        = ""
+
+	// initialized by registerFlags() due to custom type.
 	flagDirectories   globList
 	flagExcludedDirs  globList
 	flagExcludedFiles globList
 	flagIncludedFiles globList
+
+	// flagBuildSteps and its companions describe a multi-stage build
+	// pipeline; see buildsteps.go. When no -build-step is given, -build
+	// is used as a single implicit step, as before.
+	flagBuildSteps   buildStepFlag
+	flagStepDirs     keyedList
+	flagStepEnvs     envList
+	flagStepPatterns keyedList
+	flagStepDeps     keyedList
 )
 
 func okColor(format string, args ...interface{}) string {
-	if *flagColor {
+	if flagColor {
 		return color.GreenString(format, args...)
 	} else {
 		return fmt.Sprintf(format, args...)
@@ -136,50 +214,114 @@ func okColor(format string, args ...interface{}) string {
 }
 
 func failColor(format string, args ...interface{}) string {
-	if *flagColor {
+	if flagColor {
 		return color.RedString(format, args...)
 	} else {
 		return fmt.Sprintf(format, args...)
 	}
 }
 
-// Run `go build` and print the output if something's gone wrong.
-func build() bool {
-	log.Println(okColor("Running build command!"))
+// resolveBuildSteps turns the -build-step family of flags into an
+// ordered build pipeline. When no -build-step was given, -build is used as
+// a single implicit step so the legacy single-command invocation keeps
+// working unchanged.
+func resolveBuildSteps() ([]*buildStep, error) {
+	if len(flagBuildSteps.steps) == 0 {
+		return []*buildStep{{name: "build", command: flagBuild, dir: flagBuildDir}}, nil
+	}
 
-	args := strings.Split(*flagBuild, " ")
-	if len(args) == 0 {
-		// If the user has specified and empty then we are done.
-		return true
+	if err := applyStepDirs(&flagBuildSteps, &flagStepDirs); err != nil {
+		return nil, err
+	}
+	if err := applyStepEnvs(&flagBuildSteps, &flagStepEnvs); err != nil {
+		return nil, err
+	}
+	if err := applyStepPatterns(&flagBuildSteps, &flagStepPatterns); err != nil {
+		return nil, err
+	}
+	if err := applyStepDeps(&flagBuildSteps, &flagStepDeps); err != nil {
+		return nil, err
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
+	return orderedSteps(flagBuildSteps.steps)
+}
 
-	if *flagBuildDir != "" {
-		cmd.Dir = *flagBuildDir
-	} else if len(flagDirectories) > 0 {
-		cmd.Dir = flagDirectories[0]
+// runBuildPipeline runs every step that eventPath triggers, in dependency
+// order, stopping at the first failing step. An empty eventPath (as used
+// for the very first build) runs the whole pipeline. changed reports
+// whether anything actually got (re)built - it is false on a cache hit and
+// when eventPath matches no step at all, which callers use to decide
+// whether restarting -command is warranted.
+//
+// When -cache is enabled and the whole pipeline is about to run, it is
+// looked up by the hash of every tracked file plus the steps themselves
+// (see buildCacheKey); a hit means the tracked file set is byte-identical
+// to the last successful build, so the pipeline is skipped entirely and
+// the cached result is replayed. This is what lets an unrelated save
+// (e.g. a README caught by a loose -pattern) avoid a slow no-op rebuild.
+func runBuildPipeline(steps []*buildStep, eventPath string) (ok bool, combinedOutput string, duration time.Duration, changed bool) {
+	toRun := steps
+	if eventPath != "" {
+		toRun = stepsTriggeredBy(steps, eventPath)
 	}
 
-	output, err := cmd.CombinedOutput()
+	if len(toRun) == 0 {
+		return true, "", 0, false
+	}
 
-	if err == nil {
-		log.Println(okColor("Build ok."))
-	} else {
-		log.Println(failColor("Error while building:\n"), failColor(string(output)))
+	var cacheKey string
+	if cache != nil && len(toRun) == len(steps) {
+		cacheKey = buildCacheKey(steps, trackedFiles())
+		if cached, hit := cache.Get(cacheKey); hit {
+			log.Println(okColor("Build cache hit, skipping rebuild."))
+			return cached.OK, cached.Output, 0, false
+		}
+	}
+
+	log.Println(okColor("Running build command!"))
+
+	var output strings.Builder
+	started := time.Now()
+
+	for _, s := range toRun {
+		if len(steps) > 1 {
+			log.Println(okColor("Build step %q...", s.name))
+		}
+
+		stepOutput, err := runStep(s)
+		output.Write(stepOutput)
+
+		if err != nil {
+			log.Println(failColor("Error while building (step %q):\n", s.name), failColor("%s", stepOutput))
+			return false, output.String(), time.Since(started), true
+		}
 	}
 
-	return err == nil
+	log.Println(okColor("Build ok."))
+	if cacheKey != "" {
+		cache.Put(cacheKey, cachedResult{OK: true, Output: output.String()})
+	}
+	return true, output.String(), time.Since(started), true
 }
 
 func matchesPattern(pattern *regexp.Regexp, file string) bool {
 	return pattern.MatchString(file)
 }
 
+// buildResult is what builder() reports to runner()/flusher() for each
+// build attempt. Changed is false only on a cache hit - runBuildPipeline
+// didn't actually execute the pipeline, so restarting -command would just
+// bounce an already up-to-date process for no reason.
+type buildResult struct {
+	OK      bool
+	Changed bool
+}
+
 // Accept build jobs and start building when there are no jobs rushing in.
 // The inrush protection is WorkDelay milliseconds long, in this period
-// every incoming job will reset the timer.
-func builder(jobs <-chan string, buildStarted chan<- string, buildDone chan<- bool) {
+// every incoming job will reset the timer. Only the steps that eventPath
+// triggers (plus everything downstream of them) are run; see buildsteps.go.
+func builder(jobs <-chan string, buildStarted chan<- string, buildDone chan<- buildResult, steps []*buildStep) {
 	createThreshold := func() <-chan time.Time {
 		return time.After(time.Duration(WorkDelay * time.Millisecond))
 	}
@@ -193,7 +335,15 @@ func builder(jobs <-chan string, buildStarted chan<- string, buildDone chan<- bo
 			threshold = createThreshold()
 		case <-threshold:
 			buildStarted <- eventPath
-			buildDone <- build()
+			events.Publish(streamEvent{Type: eventBuildStarted, Path: eventPath})
+
+			ok, output, duration, changed := runBuildPipeline(steps, eventPath)
+
+			events.Publish(streamEvent{Type: eventBuildFinished, OK: &ok, DurationMS: duration.Milliseconds(), Output: output})
+			if ok && liveReload != nil {
+				liveReload.broadcastReload(eventPath)
+			}
+			buildDone <- buildResult{OK: ok, Changed: changed}
 		}
 	}
 }
@@ -210,7 +360,7 @@ func logger(pipeChan <-chan io.ReadCloser) {
 				break readloop
 			}
 
-			if *flagLogPrefix {
+			if flagLogPrefix {
 				log.Print(prefix, " ", line)
 			} else {
 				log.Print(line)
@@ -232,8 +382,8 @@ func startCommand(command string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr i
 	args := strings.Split(command, " ")
 	cmd = exec.Command(args[0], args[1:]...)
 
-	if *flagRunDir != "" {
-		cmd.Dir = *flagRunDir
+	if flagRunDir != "" {
+		cmd.Dir = flagRunDir
 	}
 
 	if stdout, err = cmd.StdoutPipe(); err != nil {
@@ -246,17 +396,24 @@ func startCommand(command string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr i
 		return
 	}
 
+	setProcessGroupId(cmd)
+
 	if err = cmd.Start(); err != nil {
 		err = fmt.Errorf("can't start command: %s", err)
 		return
 	}
 
+	if err = postStart(cmd.Process); err != nil {
+		err = fmt.Errorf("can't prepare command for graceful termination: %s", err)
+		return
+	}
+
 	return
 }
 
 // Run the command in the given string and restart it after
 // a message was received on the buildDone channel.
-func runner(commandTemplate string, buildStarted <-chan string, buildSuccess <-chan bool) {
+func runner(commandTemplate string, buildStarted <-chan string, buildSuccess <-chan buildResult) {
 	var currentProcess *os.Process
 	pipeChan := make(chan io.ReadCloser)
 
@@ -284,8 +441,13 @@ func runner(commandTemplate string, buildStarted <-chan string, buildSuccess <-c
 		// format specifier if the user did not supply one.
 		command := fmt.Sprintf("%0.s"+commandTemplate, eventPath)
 
-		if !*flagCommandStop {
-			if !<-buildSuccess {
+		if !flagCommandStop {
+			result := <-buildSuccess
+			if !result.OK {
+				continue
+			}
+			if !result.Changed && currentProcess != nil {
+				log.Println(okColor("Build served from cache; leaving the running command alone."))
 				continue
 			}
 		}
@@ -294,9 +456,9 @@ func runner(commandTemplate string, buildStarted <-chan string, buildSuccess <-c
 			killProcess(currentProcess)
 		}
 
-		if *flagCommandStop {
+		if flagCommandStop {
 			log.Println(okColor("Command stopped. Waiting for build to complete."))
-			if !<-buildSuccess {
+			if result := <-buildSuccess; !result.OK {
 				continue
 			}
 		}
@@ -312,11 +474,12 @@ func runner(commandTemplate string, buildStarted <-chan string, buildSuccess <-c
 		pipeChan <- stderrPipe
 
 		currentProcess = cmd.Process
+		events.Publish(streamEvent{Type: eventProcessStarted, PID: currentProcess.Pid})
 	}
 }
 
 func killProcess(process *os.Process) {
-	if *flagGracefulKill {
+	if flagGracefulKill {
 		killProcessGracefully(process)
 	} else {
 		killProcessHard(process)
@@ -330,9 +493,11 @@ func killProcessHard(process *os.Process) {
 		log.Println(failColor("Warning: could not kill child process.  It may have already exited."))
 	}
 
-	if _, err := process.Wait(); err != nil {
+	state, err := process.Wait()
+	if err != nil {
 		log.Fatal(failColor("Could not wait for child process. Aborting due to danger of infinite forks."))
 	}
+	events.Publish(streamEvent{Type: eventProcessExited, PID: process.Pid, Code: state.ExitCode()})
 }
 
 func killProcessGracefully(process *os.Process) {
@@ -343,12 +508,15 @@ func killProcessGracefully(process *os.Process) {
 			done <- err
 			return
 		}
-		_, err := process.Wait()
+		state, err := process.Wait()
+		if err == nil {
+			events.Publish(streamEvent{Type: eventProcessExited, PID: process.Pid, Code: state.ExitCode()})
+		}
 		done <- err
 	}()
 
 	select {
-	case <-time.After(time.Duration(*flagGracefulTimeout) * time.Second):
+	case <-time.After(time.Duration(flagGracefulTimeout) * time.Second):
 		log.Println(failColor("Could not gracefully stop the current process, proceeding to hard stop."))
 		killProcessHard(process)
 		<-done
@@ -359,22 +527,84 @@ func killProcessGracefully(process *os.Process) {
 	}
 }
 
-func flusher(buildStarted <-chan string, buildSuccess <-chan bool) {
+func flusher(buildStarted <-chan string, buildSuccess <-chan buildResult) {
 	for {
 		<-buildStarted
 		<-buildSuccess
 	}
 }
 
-func main() {
-	flag.Var(&flagDirectories, "directory", "Directory to watch for changes, can be set more than once")
-	flag.Var(&flagExcludedDirs, "exclude-dir", " Don't watch directories matching this name, can be set more than once")
-	flag.Var(&flagExcludedFiles, "exclude", " Don't watch files matching this name, can be set more than once")
-	flag.Var(&flagIncludedFiles, "include", " Watch files matching this name, can be set more than once")
+// runOnce resolves the build pipeline and runs every step exactly once,
+// reporting whether it succeeded. This is what the "once" subcommand uses
+// for a single CI-style build+exit instead of watching forever.
+func runOnce() bool {
+	buildSteps, err := resolveBuildSteps()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cache, err = newBuildCache(flagCache, flagCacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !flagLogPrefix {
+		log.SetFlags(0)
+	}
+
+	ok, _, _, _ := runBuildPipeline(buildSteps, "")
+	return ok
+}
+
+// printResolvedConfig validates the build pipeline and prints the set of
+// directories, file filters and build steps CompileDaemon would use if
+// started now, without touching the filesystem watcher. This backs the
+// "check" subcommand.
+func printResolvedConfig() error {
+	buildSteps, err := resolveBuildSteps()
+	if err != nil {
+		return err
+	}
+
+	directories := flagDirectories
+	if len(directories) == 0 {
+		directories = globList([]string{"."})
+	}
+
+	fmt.Println("Watched directories:", []string(directories), "(recursive:", flagRecursive, ")")
+	fmt.Println("Watched file pattern:", flagPattern)
+	if len(flagIncludedFiles) > 0 {
+		fmt.Println("Included files:", []string(flagIncludedFiles))
+	}
+	if len(flagExcludedDirs) > 0 {
+		fmt.Println("Excluded directories:", []string(flagExcludedDirs))
+	}
+	if len(flagExcludedFiles) > 0 {
+		fmt.Println("Excluded files:", []string(flagExcludedFiles))
+	}
+
+	fmt.Println("Build pipeline:")
+	for _, s := range buildSteps {
+		fmt.Printf("  - %s: %s\n", s.name, s.command)
+	}
+
+	fmt.Println("Build cache:", flagCache)
 
-	flag.Parse()
+	return nil
+}
+
+// runDaemon is the historical default behavior of CompileDaemon: watch the
+// configured directories forever, rebuilding (and, if -command is set,
+// restarting the child process) on every relevant change. It is what the
+// "run" subcommand, and the legacy flat-flag invocation via the
+// compatibility shim in cli.go, both execute.
+func runDaemon() {
+	buildSteps, err := resolveBuildSteps()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if !*flagLogPrefix {
+	if !flagLogPrefix {
 		log.SetFlags(0)
 	}
 
@@ -382,10 +612,31 @@ func main() {
 		flagDirectories = globList([]string{"."})
 	}
 
-	if *flagGracefulKill && !gracefulTerminationPossible() {
+	if flagGracefulKill && !gracefulTerminationPossible() {
 		log.Fatal("Graceful termination is not supported on your platform.")
 	}
 
+	cache, err = newBuildCache(flagCache, flagCacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flagLiveReload != "" {
+		liveReload = newLiveReloadServer()
+		go func() {
+			log.Fatal(liveReload.ListenAndServe(flagLiveReload))
+		}()
+	}
+
+	if flagEventStream != "" {
+		var err error
+		events, err = startEventStream(flagEventStream)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer events.Close()
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 
 	if err != nil {
@@ -395,13 +646,13 @@ func main() {
 	defer watcher.Close()
 
 	for _, flagDirectory := range flagDirectories {
-		if *flagRecursive == true {
+		if flagRecursive == true {
 			err = filepath.Walk(flagDirectory, func(path string, info os.FileInfo, err error) error {
 				if err == nil && info.IsDir() {
 					if flagExcludedDirs.Matches(path) {
 						return filepath.SkipDir
 					} else {
-						if *flagVerbose {
+						if flagVerbose {
 							log.Printf("Watching directory '%s' for changes.\n", path)
 						}
 						return watcher.Add(path)
@@ -424,15 +675,16 @@ func main() {
 		}
 	}
 
-	pattern := regexp.MustCompile(*flagPattern)
+	pattern := regexp.MustCompile(flagPattern)
 	jobs := make(chan string)
-	buildSuccess := make(chan bool)
+	buildSuccess := make(chan buildResult)
 	buildStarted := make(chan string)
+	suppressor := newEventSuppressor(flagDebounce, flagHashCheck, flagSuppressCache)
 
-	go builder(jobs, buildStarted, buildSuccess)
+	go builder(jobs, buildStarted, buildSuccess, buildSteps)
 
-	if *flagCommand != "" {
-		go runner(*flagCommand, buildStarted, buildSuccess)
+	if flagCommand != "" {
+		go runner(flagCommand, buildStarted, buildSuccess)
 	} else {
 		go flusher(buildStarted, buildSuccess)
 	}
@@ -444,12 +696,13 @@ func main() {
 				base := filepath.Base(ev.Name)
 
 				// Assume it is a directory and track it.
-				if *flagRecursive == true && !flagExcludedDirs.Matches(ev.Name) {
+				if flagRecursive == true && !flagExcludedDirs.Matches(ev.Name) {
 					watcher.Add(ev.Name)
 				}
 
 				if flagIncludedFiles.Matches(base) || matchesPattern(pattern, ev.Name) {
-					if !flagExcludedFiles.Matches(base) {
+					if !flagExcludedFiles.Matches(base) && !suppressor.ShouldSuppress(ev.Name) {
+						events.Publish(streamEvent{Type: eventFileChanged, Path: ev.Name})
 						jobs <- ev.Name
 					}
 				}
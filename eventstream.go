@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventWriteTimeout bounds how long a single subscriber write may block;
+// eventOutboxSize bounds how many events can queue up behind a stalled
+// subscriber before it's dropped. Both exist so a dead unix socket peer or
+// an IDE that stopped reading can never stall Publish's caller (builder(),
+// runner(), killProcessHard/Gracefully).
+const (
+	eventWriteTimeout = 5 * time.Second
+	eventOutboxSize   = 16
+)
+
+// eventType identifies the kind of structured event published on the
+// event stream; see the -event-stream flag.
+type eventType string
+
+const (
+	eventFileChanged    eventType = "file_changed"
+	eventBuildStarted   eventType = "build_started"
+	eventBuildFinished  eventType = "build_finished"
+	eventProcessStarted eventType = "process_started"
+	eventProcessExited  eventType = "process_exited"
+)
+
+// streamEvent is the newline-delimited JSON record published to every
+// -event-stream subscriber. Only the fields relevant to Type are set; the
+// rest are omitted.
+type streamEvent struct {
+	Type       eventType `json:"type"`
+	Path       string    `json:"path,omitempty"`
+	OK         *bool     `json:"ok,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	Code       int       `json:"code,omitempty"`
+}
+
+// eventHub fans the structured event stream out to every connected
+// subscriber (an IDE plugin, a dashboard, a CI tap, ...) without one slow
+// or gone subscriber blocking the others: Publish only ever enqueues onto a
+// subscriber's outbox, the actual write happens in that subscriber's own
+// writeLoop goroutine.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[io.WriteCloser]chan []byte
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[io.WriteCloser]chan []byte)}
+}
+
+// Subscribe registers w to receive every event published from now on,
+// until it errors or times out on a write, or the hub is closed.
+func (h *eventHub) Subscribe(w io.WriteCloser) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	outbox := make(chan []byte, eventOutboxSize)
+	h.subs[w] = outbox
+	go h.writeLoop(w, outbox)
+}
+
+// writeDeadliner is implemented by subscribers (net.Conn) that can bound
+// how long a single write may block; a plain io.WriteCloser (e.g. stdout)
+// just writes without one.
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// writeLoop is the only goroutine that ever writes to w.
+func (h *eventHub) writeLoop(w io.WriteCloser, outbox chan []byte) {
+	for line := range outbox {
+		if dw, ok := w.(writeDeadliner); ok {
+			dw.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		}
+		if _, err := w.Write(line); err != nil {
+			h.unsubscribe(w)
+			return
+		}
+	}
+}
+
+func (h *eventHub) unsubscribe(w io.WriteCloser) {
+	h.mu.Lock()
+	outbox, ok := h.subs[w]
+	delete(h.subs, w)
+	h.mu.Unlock()
+
+	if ok {
+		close(outbox)
+	}
+	w.Close()
+}
+
+// Publish encodes e as a single line of JSON and enqueues it for every
+// current subscriber. A subscriber whose outbox is already full is assumed
+// stuck and dropped, so Publish itself never blocks.
+func (h *eventHub) Publish(e streamEvent) {
+	if h == nil {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Println(failColor("event-stream: could not encode event: %s", err))
+		return
+	}
+	line = append(line, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w, outbox := range h.subs {
+		select {
+		case outbox <- line:
+		default:
+			log.Println(failColor("event-stream: subscriber outbox full, dropping it"))
+			go h.unsubscribe(w)
+		}
+	}
+}
+
+// Close disconnects every subscriber.
+func (h *eventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for w, outbox := range h.subs {
+		close(outbox)
+		w.Close()
+	}
+	h.subs = make(map[io.WriteCloser]chan []byte)
+}
+
+// nopCloserWriter adapts an io.Writer that must not actually be closed
+// (e.g. os.Stdout) to the io.WriteCloser eventHub subscribers need.
+type nopCloserWriter struct {
+	io.Writer
+}
+
+func (nopCloserWriter) Close() error { return nil }
+
+// startEventStream parses -event-stream and wires up an eventHub
+// accordingly:
+//
+//	stdout           – every event is written to CompileDaemon's own stdout
+//	unix:/path/to.sock – a unix socket is listened on; every connection is a subscriber
+//	tcp::9000        – a TCP socket is listened on; every connection is a subscriber
+func startEventStream(spec string) (*eventHub, error) {
+	hub := newEventHub()
+
+	if spec == "stdout" {
+		hub.Subscribe(nopCloserWriter{os.Stdout})
+		return hub, nil
+	}
+
+	network, address, err := parseEventStreamAddr(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("event-stream: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Println(failColor("event-stream: accept failed: %s", err))
+				return
+			}
+			hub.Subscribe(conn)
+		}
+	}()
+
+	return hub, nil
+}
+
+func parseEventStreamAddr(spec string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(spec, "unix:"):
+		return "unix", strings.TrimPrefix(spec, "unix:"), nil
+	case strings.HasPrefix(spec, "tcp:"):
+		return "tcp", strings.TrimPrefix(spec, "tcp:"), nil
+	default:
+		return "", "", fmt.Errorf("event-stream: unrecognized address %q, want stdout, unix:PATH or tcp:ADDR", spec)
+	}
+}
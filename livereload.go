@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// liveReloadWriteTimeout bounds how long a single client write may block;
+// liveReloadOutboxSize bounds how many reloads can queue up behind a
+// stalled client before it's dropped. Both exist so one backgrounded
+// browser tab can never stall builder()'s call to broadcastReload.
+const (
+	liveReloadWriteTimeout = 5 * time.Second
+	liveReloadOutboxSize   = 4
+)
+
+// livereloadJS is the tiny client shim served at /livereload.js. It opens a
+// websocket back to us and reloads the page (or swaps a <link> tag for
+// CSS-only changes) whenever we broadcast a message.
+const livereloadJS = `(function() {
+	var proto = location.protocol === "https:" ? "wss://" : "ws://";
+	var socket = new WebSocket(proto + location.host + "/livereload/ws");
+	socket.onmessage = function(event) {
+		var msg = JSON.parse(event.data);
+		if (msg.path && /\.css$/i.test(msg.path)) {
+			var links = document.getElementsByTagName("link");
+			for (var i = 0; i < links.length; i++) {
+				var link = links[i];
+				if (link.rel === "stylesheet") {
+					var url = new URL(link.href);
+					url.searchParams.set("_livereload", Date.now());
+					link.href = url.toString();
+				}
+			}
+			return;
+		}
+		location.reload();
+	};
+})();
+`
+
+// reloadMessage is broadcast to every connected browser after a successful
+// build. Path is the file that triggered the rebuild, if any, which lets
+// the client distinguish a CSS-only edit from a full page reload.
+type reloadMessage struct {
+	Path string `json:"path"`
+}
+
+// liveReloadServer embeds a tiny HTTP server that speaks (a subset of) the
+// LiveReload protocol: it serves livereload.js and upgrades /livereload/ws
+// to a websocket that gets a message every time builder() finishes a
+// successful build.
+type liveReloadServer struct {
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*websocket.Conn]chan reloadMessage
+}
+
+func newLiveReloadServer() *liveReloadServer {
+	return &liveReloadServer{
+		clients: make(map[*websocket.Conn]chan reloadMessage),
+	}
+}
+
+func (s *liveReloadServer) handleScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Write([]byte(livereloadJS))
+}
+
+func (s *liveReloadServer) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(failColor("livereload: could not upgrade connection: %s", err))
+		return
+	}
+
+	outbox := make(chan reloadMessage, liveReloadOutboxSize)
+
+	s.mu.Lock()
+	s.clients[conn] = outbox
+	s.mu.Unlock()
+
+	go s.writeLoop(conn, outbox)
+
+	// Drain and discard anything the client sends us; we only care about
+	// noticing when it goes away.
+	go func() {
+		defer s.removeClient(conn)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// writeLoop is the only goroutine that ever writes to conn. broadcastReload
+// just enqueues onto outbox, so a stalled browser tab blocks at most this
+// goroutine - never the builder() goroutine or another client's delivery.
+func (s *liveReloadServer) writeLoop(conn *websocket.Conn, outbox chan reloadMessage) {
+	for msg := range outbox {
+		conn.SetWriteDeadline(time.Now().Add(liveReloadWriteTimeout))
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Println(failColor("livereload: could not notify client: %s", err))
+			s.removeClient(conn)
+			return
+		}
+	}
+}
+
+func (s *liveReloadServer) removeClient(conn *websocket.Conn) {
+	s.mu.Lock()
+	outbox, ok := s.clients[conn]
+	delete(s.clients, conn)
+	s.mu.Unlock()
+
+	if ok {
+		close(outbox)
+	}
+	conn.Close()
+}
+
+// broadcastReload notifies every connected browser about a successful
+// build. eventPath is the file that triggered it, used by the client shim
+// to decide between a stylesheet swap and a full reload.
+//
+// This only enqueues onto each client's outbox; writeLoop does the actual
+// write under its own write deadline. A client whose outbox is already full
+// is assumed stuck and dropped, so broadcastReload itself never blocks.
+func (s *liveReloadServer) broadcastReload(eventPath string) {
+	msg := reloadMessage{Path: filepath.ToSlash(eventPath)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, outbox := range s.clients {
+		select {
+		case outbox <- msg:
+		default:
+			log.Println(failColor("livereload: client outbox full, dropping it"))
+			go s.removeClient(conn)
+		}
+	}
+}
+
+// ListenAndServe starts the livereload HTTP server on addr. It runs until
+// the listener fails and is meant to be started in its own goroutine.
+func (s *liveReloadServer) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload.js", s.handleScript)
+	mux.HandleFunc("/livereload/ws", s.handleWebsocket)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println(okColor("Serving livereload.js and websocket on %s", addr))
+
+	return http.Serve(listener, mux)
+}
+
@@ -19,6 +19,13 @@ func setProcessGroupId(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 }
 
+// postStart has nothing left to do on POSIX: setProcessGroupId already put
+// the child in its own process group, which is all terminateGracefully and
+// terminateHard need.
+func postStart(process *os.Process) error {
+	return nil
+}
+
 func terminateGracefully(process *os.Process) error {
 	return syscall.Kill(-process.Pid, syscall.SIGTERM)
 }
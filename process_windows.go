@@ -1,8 +1,14 @@
 package main
 
 import (
-	"errors"
+	"fmt"
 	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
 )
 
 var fatalSignals = []os.Signal{
@@ -10,18 +16,82 @@ var fatalSignals = []os.Signal{
 	os.Kill,
 }
 
+// job is the Windows Job Object every child process is assigned to (via
+// postStart/assignToJob) once it starts. JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+// means Windows tears down the whole process tree, including any
+// grandchildren the child itself spawned, if CompileDaemon exits without
+// having cleaned them up itself.
+var (
+	jobOnce sync.Once
+	job     windows.Handle
+	jobErr  error
+)
+
+func sharedJob() (windows.Handle, error) {
+	jobOnce.Do(func() {
+		job, jobErr = windows.CreateJobObject(nil, nil)
+		if jobErr != nil {
+			return
+		}
+
+		info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+			BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+				LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+			},
+		}
+
+		_, jobErr = windows.SetInformationJobObject(
+			job,
+			windows.JobObjectExtendedLimitInformation,
+			uintptr(unsafe.Pointer(&info)),
+			uint32(unsafe.Sizeof(info)),
+		)
+	})
+
+	return job, jobErr
+}
+
+// setProcessGroupId spawns the child in its own process group, via
+// CREATE_NEW_PROCESS_GROUP, so that a later CTRL_BREAK_EVENT sent to that
+// group (see terminateGracefully) reaches only the child and not
+// CompileDaemon itself.
 func setProcessGroupId(cmd *exec.Cmd) {
-	// TODO implement this for windows as well
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// postStart assigns the now-started process to the shared job object so
+// it (and anything it spawns) is cleaned up if CompileDaemon dies first.
+func postStart(process *os.Process) error {
+	j, err := sharedJob()
+	if err != nil {
+		return fmt.Errorf("create job object: %w", err)
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(process.Pid))
+	if err != nil {
+		return fmt.Errorf("open process %d: %w", process.Pid, err)
+	}
+	defer windows.CloseHandle(handle)
+
+	if err := windows.AssignProcessToJobObject(j, handle); err != nil {
+		return fmt.Errorf("assign process %d to job object: %w", process.Pid, err)
+	}
+
+	return nil
 }
 
 func terminateHard(process *os.Process) error {
 	return process.Kill()
 }
 
+// terminateGracefully asks the child to shut down cleanly by sending it a
+// CTRL_BREAK_EVENT. A child in its own process group (see
+// setProcessGroupId) that has installed a signal.Notify(..., os.Interrupt)
+// handler receives this the same way a POSIX child receives SIGTERM.
 func terminateGracefully(process *os.Process) error {
-	return errors.New("terminateGracefully not implemented on windows")
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(process.Pid))
 }
 
 func gracefulTerminationPossible() bool {
-	return false
+	return true
 }
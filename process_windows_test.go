@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"testing"
+	"time"
+)
+
+// TestMain lets this test binary double as its own helper process: when
+// invoked with GO_WANT_HELPER_PROCESS=1 it installs a signal.Notify(...,
+// os.Interrupt) handler, which is exactly what a CTRL_BREAK_EVENT surfaces
+// as, prints "ready" once it's listening and "notified" once the signal
+// arrives, then exits cleanly. This is the same re-exec pattern the
+// standard library uses in os/exec_test.go.
+func TestMain(m *testing.M) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
+		runHelperProcess()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func runHelperProcess() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	fmt.Println("ready")
+	<-sig
+	fmt.Println("notified")
+	os.Exit(0)
+}
+
+// TestTerminateGracefullyReachesChild proves that terminateGracefully
+// actually reaches a child process rather than being the no-op it used to
+// be on Windows: it starts this same test binary as a helper process via
+// setProcessGroupId/postStart, the same way startCommand does, sends it a
+// graceful termination request, and checks the helper's
+// signal.Notify(os.Interrupt) handler observed it before the process
+// exited.
+func TestTerminateGracefullyReachesChild(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestMain")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+	setProcessGroupId(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %s", err)
+	}
+	lines := bufio.NewScanner(stdout)
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	defer cmd.Process.Kill()
+
+	if err := postStart(cmd.Process); err != nil {
+		t.Fatalf("postStart: %s", err)
+	}
+
+	readLine := func() (string, bool) {
+		type result struct {
+			line string
+			ok   bool
+		}
+		out := make(chan result, 1)
+		go func() {
+			ok := lines.Scan()
+			out <- result{lines.Text(), ok}
+		}()
+		select {
+		case r := <-out:
+			return r.line, r.ok
+		case <-time.After(5 * time.Second):
+			return "", false
+		}
+	}
+
+	if line, ok := readLine(); !ok || line != "ready" {
+		t.Fatalf("helper process did not become ready (got %q)", line)
+	}
+
+	if err := terminateGracefully(cmd.Process); err != nil {
+		t.Fatalf("terminateGracefully: %s", err)
+	}
+
+	if line, ok := readLine(); !ok || line != "notified" {
+		t.Fatalf("helper process did not observe the graceful termination request (got %q)", line)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("helper process exited with error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("helper process did not exit after observing the graceful termination request")
+	}
+}